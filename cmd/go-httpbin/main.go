@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"log"
@@ -10,32 +12,83 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/mccutchen/go-httpbin/v2/httpbin"
+	"golang.org/x/net/netutil"
 )
 
 const (
-	defaultHost = "0.0.0.0"
-	defaultPort = 8080
+	defaultHost            = "0.0.0.0"
+	defaultPort            = 8080
+	defaultHTTPSClientAuth = "none"
 )
 
+// clientAuthTypes maps the -https-client-auth flag values to the
+// tls.ClientAuthType constants they configure.
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify":             tls.VerifyClientCertIfGiven,
+	"require-and-verify": tls.RequireAndVerifyClientCert,
+}
+
+// tlsVersions maps the -tls-min-version/-tls-max-version flag values to the
+// tls.VersionTLSxx constants they configure.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
 var (
-	host            string
-	port            int
-	maxBodySize     int64
-	maxDuration     time.Duration
-	httpsCertFile   string
-	httpsKeyFile    string
-	useRealHostname bool
+	host              string
+	port              int
+	httpsPort         int
+	unixSocket        string
+	unixSocketMode    string
+	maxConnections    int
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	keepAlive         bool
+	maxBodySize       int64
+	maxDuration       time.Duration
+	httpsCertFile     string
+	httpsKeyFile      string
+	httpsClientCAFile string
+	httpsClientAuth   string
+	tlsMinVersion     string
+	tlsMaxVersion     string
+	shutdownDelay     time.Duration
+	shutdownTimeout   time.Duration
+	useRealHostname   bool
 )
 
 func main() {
 	flag.StringVar(&host, "host", defaultHost, "Host to listen on")
 	flag.IntVar(&port, "port", defaultPort, "Port to listen on")
+	flag.IntVar(&httpsPort, "https-port", 0, "Port to listen on for HTTPS, in addition to -port, when -https-cert-file and -https-key-file are given")
+	flag.StringVar(&unixSocket, "unix-socket", "", "Path to a unix domain socket to listen on, instead of a TCP host:port")
+	flag.StringVar(&unixSocketMode, "unix-socket-mode", "", "File mode (e.g. 0666) to chmod the unix socket to after it is created")
+	flag.IntVar(&maxConnections, "max-connections", 0, "Maximum number of simultaneous connections to accept (0 = unlimited)")
+	flag.DurationVar(&readTimeout, "read-timeout", 0, "Maximum duration for reading the entire request, including the body (0 = no timeout)")
+	flag.DurationVar(&writeTimeout, "write-timeout", 0, "Maximum duration before timing out writes of the response (0 = no timeout)")
+	flag.DurationVar(&idleTimeout, "idle-timeout", 0, "Maximum amount of time to wait for the next request when keep-alives are enabled (0 = use -read-timeout, or no timeout if that is also 0)")
+	flag.BoolVar(&keepAlive, "keepalive", true, "Enable HTTP keep-alives")
 	flag.StringVar(&httpsCertFile, "https-cert-file", "", "HTTPS Server certificate file")
 	flag.StringVar(&httpsKeyFile, "https-key-file", "", "HTTPS Server private key file")
+	flag.StringVar(&httpsClientCAFile, "https-client-ca", "", "PEM file of CA certificates used to verify client certificates, for mutual TLS")
+	flag.StringVar(&httpsClientAuth, "https-client-auth", defaultHTTPSClientAuth, "Client certificate auth policy: none, request, require, verify, require-and-verify")
+	flag.StringVar(&tlsMinVersion, "tls-min-version", "", "Minimum TLS version to accept, e.g. 1.2 or 1.3")
+	flag.StringVar(&tlsMaxVersion, "tls-max-version", "", "Maximum TLS version to accept, e.g. 1.2 or 1.3")
+	flag.DurationVar(&shutdownDelay, "shutdown-delay", 0, "Time to wait after the shutdown signal before draining connections, with /ready reporting unhealthy (0 = shut down immediately)")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 0, "Maximum time to wait for in-flight requests to finish after the drain delay elapses (0 = max-duration + 1s)")
 	flag.Int64Var(&maxBodySize, "max-body-size", httpbin.DefaultMaxBodySize, "Maximum size of request or response, in bytes")
 	flag.DurationVar(&maxDuration, "max-duration", httpbin.DefaultMaxDuration, "Maximum duration a response may take")
 	flag.BoolVar(&useRealHostname, "use-real-hostname", false, "Expose value of os.Hostname() in the /hostname endpoint instead of dummy value")
@@ -61,6 +114,41 @@ func main() {
 			os.Exit(1)
 		}
 	}
+	if maxConnections == 0 && os.Getenv("MAX_CONNECTIONS") != "" {
+		maxConnections, err = strconv.Atoi(os.Getenv("MAX_CONNECTIONS"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid value %#v for env var MAX_CONNECTIONS: %s\n\n", os.Getenv("MAX_CONNECTIONS"), err)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+	if readTimeout == 0 && os.Getenv("READ_TIMEOUT") != "" {
+		readTimeout, err = time.ParseDuration(os.Getenv("READ_TIMEOUT"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid value %#v for env var READ_TIMEOUT: %s\n\n", os.Getenv("READ_TIMEOUT"), err)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+	if writeTimeout == 0 && os.Getenv("WRITE_TIMEOUT") != "" {
+		writeTimeout, err = time.ParseDuration(os.Getenv("WRITE_TIMEOUT"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid value %#v for env var WRITE_TIMEOUT: %s\n\n", os.Getenv("WRITE_TIMEOUT"), err)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+	if idleTimeout == 0 && os.Getenv("IDLE_TIMEOUT") != "" {
+		idleTimeout, err = time.ParseDuration(os.Getenv("IDLE_TIMEOUT"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid value %#v for env var IDLE_TIMEOUT: %s\n\n", os.Getenv("IDLE_TIMEOUT"), err)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+	if keepAliveEnv := os.Getenv("KEEPALIVE"); keepAliveEnv == "0" || keepAliveEnv == "false" {
+		keepAlive = false
+	}
 	if host == defaultHost && os.Getenv("HOST") != "" {
 		host = os.Getenv("HOST")
 	}
@@ -72,6 +160,14 @@ func main() {
 			os.Exit(1)
 		}
 	}
+	if httpsPort == 0 && os.Getenv("HTTPS_PORT") != "" {
+		httpsPort, err = strconv.Atoi(os.Getenv("HTTPS_PORT"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid value %#v for env var HTTPS_PORT: %s\n\n", os.Getenv("HTTPS_PORT"), err)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
 
 	if httpsCertFile == "" && os.Getenv("HTTPS_CERT_FILE") != "" {
 		httpsCertFile = os.Getenv("HTTPS_CERT_FILE")
@@ -79,6 +175,37 @@ func main() {
 	if httpsKeyFile == "" && os.Getenv("HTTPS_KEY_FILE") != "" {
 		httpsKeyFile = os.Getenv("HTTPS_KEY_FILE")
 	}
+	if unixSocket == "" && os.Getenv("UNIX_SOCKET") != "" {
+		unixSocket = os.Getenv("UNIX_SOCKET")
+	}
+	if httpsClientCAFile == "" && os.Getenv("HTTPS_CLIENT_CA") != "" {
+		httpsClientCAFile = os.Getenv("HTTPS_CLIENT_CA")
+	}
+	if httpsClientAuth == defaultHTTPSClientAuth && os.Getenv("HTTPS_CLIENT_AUTH") != "" {
+		httpsClientAuth = os.Getenv("HTTPS_CLIENT_AUTH")
+	}
+	if tlsMinVersion == "" && os.Getenv("TLS_MIN_VERSION") != "" {
+		tlsMinVersion = os.Getenv("TLS_MIN_VERSION")
+	}
+	if tlsMaxVersion == "" && os.Getenv("TLS_MAX_VERSION") != "" {
+		tlsMaxVersion = os.Getenv("TLS_MAX_VERSION")
+	}
+	if shutdownDelay == 0 && os.Getenv("SHUTDOWN_DELAY") != "" {
+		shutdownDelay, err = time.ParseDuration(os.Getenv("SHUTDOWN_DELAY"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid value %#v for env var SHUTDOWN_DELAY: %s\n\n", os.Getenv("SHUTDOWN_DELAY"), err)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+	if shutdownTimeout == 0 && os.Getenv("SHUTDOWN_TIMEOUT") != "" {
+		shutdownTimeout, err = time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid value %#v for env var SHUTDOWN_TIMEOUT: %s\n\n", os.Getenv("SHUTDOWN_TIMEOUT"), err)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
 
 	var serveTLS bool
 	if httpsCertFile != "" || httpsKeyFile != "" {
@@ -89,6 +216,44 @@ func main() {
 			os.Exit(1)
 		}
 	}
+	if httpsPort != 0 && !serveTLS {
+		fmt.Fprintf(os.Stderr, "Error: -https-port requires -https-cert-file and -https-key-file\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if unixSocket != "" && serveTLS {
+		fmt.Fprintf(os.Stderr, "Error: -unix-socket cannot be combined with -https-cert-file/-https-key-file\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	clientAuthType, ok := clientAuthTypes[httpsClientAuth]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: invalid value %#v for -https-client-auth\n\n", httpsClientAuth)
+		flag.Usage()
+		os.Exit(1)
+	}
+	if (httpsClientCAFile != "" || clientAuthType != tls.NoClientCert) && !serveTLS {
+		fmt.Fprintf(os.Stderr, "Error: -https-client-ca/-https-client-auth require -https-cert-file and -https-key-file\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	var tlsMinVersionNum, tlsMaxVersionNum uint16
+	if tlsMinVersion != "" {
+		tlsMinVersionNum, ok = tlsVersions[tlsMinVersion]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Error: invalid value %#v for -tls-min-version\n\n", tlsMinVersion)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+	if tlsMaxVersion != "" {
+		tlsMaxVersionNum, ok = tlsVersions[tlsMaxVersion]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Error: invalid value %#v for -tls-max-version\n\n", tlsMaxVersion)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
 
 	// useRealHostname will be true if either the `-use-real-hostname`
 	// arg is given on the command line or if the USE_REAL_HOSTNAME env var
@@ -125,11 +290,95 @@ func main() {
 	}
 	h := httpbin.New(opts...)
 
+	// draining reports whether the server is in its pre-shutdown drain
+	// window; the /ready endpoint reports unhealthy while it is set so that
+	// a load balancer can stop routing new connections here before we start
+	// closing them.
+	var draining atomic.Bool
+	httpbinHandler := h.Handler()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ready" {
+			if draining.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		httpbinHandler.ServeHTTP(w, r)
+	})
+
+	// tlsConfig is shared by every TLS listener we start, configuring
+	// mutual TLS and min/max protocol version when requested.
+	var tlsConfig *tls.Config
+	if serveTLS {
+		tlsConfig = &tls.Config{
+			ClientAuth: clientAuthType,
+			MinVersion: tlsMinVersionNum,
+			MaxVersion: tlsMaxVersionNum,
+		}
+		if httpsClientCAFile != "" {
+			pemBytes, err := os.ReadFile(httpsClientCAFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: failed to read -https-client-ca %#v: %s\n\n", httpsClientCAFile, err)
+				os.Exit(1)
+			}
+			clientCAs := x509.NewCertPool()
+			if !clientCAs.AppendCertsFromPEM(pemBytes) {
+				fmt.Fprintf(os.Stderr, "Error: no certificates found in -https-client-ca %#v\n\n", httpsClientCAFile)
+				os.Exit(1)
+			}
+			tlsConfig.ClientCAs = clientCAs
+		}
+	}
+
+	// servers holds every http.Server we need to run and gracefully shut
+	// down together. In the common case this is just one server, but when
+	// -https-port is given alongside TLS cert/key it holds both a plaintext
+	// HTTP server and a TLS HTTPS server sharing the same handler.
+	var servers []*http.Server
+
 	listenAddr := net.JoinHostPort(host, strconv.Itoa(port))
+	mainServer := &http.Server{
+		Addr:         listenAddr,
+		Handler:      handler,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		IdleTimeout:  idleTimeout,
+	}
+	servers = append(servers, mainServer)
+
+	var httpsServer *http.Server
+	if httpsPort != 0 {
+		httpsAddr := net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		httpsServer = &http.Server{
+			Addr:         httpsAddr,
+			Handler:      handler,
+			ReadTimeout:  readTimeout,
+			WriteTimeout: writeTimeout,
+			IdleTimeout:  idleTimeout,
+			TLSConfig:    tlsConfig,
+		}
+		servers = append(servers, httpsServer)
+	} else if serveTLS {
+		mainServer.TLSConfig = tlsConfig
+	}
+	for _, srv := range servers {
+		srv.SetKeepAlivesEnabled(keepAlive)
+	}
 
-	server := &http.Server{
-		Addr:    listenAddr,
-		Handler: h.Handler(),
+	// listen opens a TCP listener for addr, wrapping it in a
+	// netutil.LimitListener when -max-connections is set so that we never
+	// hold open more than maxConnections simultaneous connections.
+	listen := func(addr string) (net.Listener, error) {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		if maxConnections > 0 {
+			l = netutil.LimitListener(l, maxConnections)
+		}
+		return l, nil
 	}
 
 	// shutdownCh triggers graceful shutdown on SIGINT or SIGTERM
@@ -139,34 +388,135 @@ func main() {
 	// exitCh will be closed when it is safe to exit, after graceful shutdown
 	exitCh := make(chan struct{})
 
+	// errCh carries listener errors from any server back to the main
+	// goroutine so that a failure on any listener triggers a clean exit.
+	errCh := make(chan error, len(servers))
+
 	go func() {
 		sig := <-shutdownCh
 		serverLog("shutdown started by signal: %s", sig)
 
-		shutdownTimeout := maxDuration + 1*time.Second
-		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if shutdownDelay > 0 {
+			draining.Store(true)
+			serverLog("draining for %s before shutdown", shutdownDelay)
+			time.Sleep(shutdownDelay)
+		}
+
+		timeout := shutdownTimeout
+		if timeout == 0 {
+			timeout = maxDuration + 1*time.Second
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
 		defer cancel()
 
-		server.SetKeepAlivesEnabled(false)
-		if err := server.Shutdown(ctx); err != nil {
-			serverLog("shutdown error: %s", err)
+		var wg sync.WaitGroup
+		for _, srv := range servers {
+			wg.Add(1)
+			go func(srv *http.Server) {
+				defer wg.Done()
+				srv.SetKeepAlivesEnabled(false)
+				if err := srv.Shutdown(ctx); err != nil {
+					serverLog("shutdown error: %s", err)
+				}
+			}(srv)
+		}
+		wg.Wait()
+
+		if unixSocket != "" {
+			if err := os.Remove(unixSocket); err != nil && !os.IsNotExist(err) {
+				serverLog("failed to remove unix socket %s: %s", unixSocket, err)
+			}
 		}
 
 		close(exitCh)
 	}()
 
-	var listenErr error
-	if serveTLS {
-		serverLog("go-httpbin listening on https://%s", listenAddr)
-		listenErr = server.ListenAndServeTLS(httpsCertFile, httpsKeyFile)
+	if unixSocket != "" {
+		go func() {
+			l, err := net.Listen("unix", unixSocket)
+			if err != nil {
+				errCh <- fmt.Errorf("unix socket listener: %w", err)
+				return
+			}
+			if maxConnections > 0 {
+				l = netutil.LimitListener(l, maxConnections)
+			}
+			if unixSocketMode != "" {
+				mode, err := strconv.ParseUint(unixSocketMode, 8, 32)
+				if err != nil {
+					errCh <- fmt.Errorf("invalid -unix-socket-mode %#v: %w", unixSocketMode, err)
+					return
+				}
+				if err := os.Chmod(unixSocket, os.FileMode(mode)); err != nil {
+					errCh <- fmt.Errorf("chmod unix socket: %w", err)
+					return
+				}
+			}
+			serverLog("go-httpbin listening on unix://%s", unixSocket)
+			err = mainServer.Serve(l)
+			if err != nil && err != http.ErrServerClosed {
+				errCh <- fmt.Errorf("unix socket listener: %w", err)
+			}
+		}()
+	} else if httpsServer != nil {
+		// Dual-listener mode: serve plaintext HTTP on mainServer and TLS on
+		// httpsServer at the same time.
+		go func() {
+			l, err := listen(mainServer.Addr)
+			if err != nil {
+				errCh <- fmt.Errorf("http listener: %w", err)
+				return
+			}
+			serverLog("go-httpbin listening on http://%s", mainServer.Addr)
+			err = mainServer.Serve(l)
+			if err != nil && err != http.ErrServerClosed {
+				errCh <- fmt.Errorf("http listener: %w", err)
+			}
+		}()
+		go func() {
+			l, err := listen(httpsServer.Addr)
+			if err != nil {
+				errCh <- fmt.Errorf("https listener: %w", err)
+				return
+			}
+			serverLog("go-httpbin listening on https://%s", httpsServer.Addr)
+			err = httpsServer.ServeTLS(l, httpsCertFile, httpsKeyFile)
+			if err != nil && err != http.ErrServerClosed {
+				errCh <- fmt.Errorf("https listener: %w", err)
+			}
+		}()
+	} else if serveTLS {
+		go func() {
+			l, err := listen(mainServer.Addr)
+			if err != nil {
+				errCh <- fmt.Errorf("https listener: %w", err)
+				return
+			}
+			serverLog("go-httpbin listening on https://%s", mainServer.Addr)
+			err = mainServer.ServeTLS(l, httpsCertFile, httpsKeyFile)
+			if err != nil && err != http.ErrServerClosed {
+				errCh <- fmt.Errorf("https listener: %w", err)
+			}
+		}()
 	} else {
-		serverLog("go-httpbin listening on http://%s", listenAddr)
-		listenErr = server.ListenAndServe()
+		go func() {
+			l, err := listen(mainServer.Addr)
+			if err != nil {
+				errCh <- fmt.Errorf("http listener: %w", err)
+				return
+			}
+			serverLog("go-httpbin listening on http://%s", mainServer.Addr)
+			err = mainServer.Serve(l)
+			if err != nil && err != http.ErrServerClosed {
+				errCh <- fmt.Errorf("http listener: %w", err)
+			}
+		}()
 	}
-	if listenErr != nil && listenErr != http.ErrServerClosed {
+
+	select {
+	case listenErr := <-errCh:
 		logger.Fatalf("failed to listen: %s", listenErr)
+	case <-exitCh:
+		serverLog("shutdown finished")
 	}
-
-	<-exitCh
-	serverLog("shutdown finished")
 }